@@ -0,0 +1,84 @@
+// Package argocd discovers charts deployed through Argo CD Applications
+// so they show up alongside natively-managed Helm releases. Argo CD
+// renders and applies charts itself, so they never appear in Helm's
+// release storage and would otherwise be invisible to the exporter.
+package argocd
+
+import (
+	"context"
+
+	"github.com/sstarcher/helm-exporter/sources"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var applicationResource = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "applications",
+}
+
+// Source lists Argo CD Applications and synthesizes a sources.Release
+// for each one that deploys a Helm chart.
+type Source struct {
+	client    dynamic.Interface
+	namespace string
+}
+
+// New returns an Argo CD ReleaseSource that lists Applications in
+// namespace using client. An empty namespace lists across all
+// namespaces.
+func New(client dynamic.Interface, namespace string) *Source {
+	return &Source{client: client, namespace: namespace}
+}
+
+// Name implements sources.ReleaseSource.
+func (s *Source) Name() string {
+	return "argocd"
+}
+
+// List implements sources.ReleaseSource.
+func (s *Source) List() ([]sources.Release, error) {
+	list, err := s.client.Resource(applicationResource).Namespace(s.namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []sources.Release
+	for _, item := range list.Items {
+		release, ok := releaseFromApplication(item)
+		if !ok {
+			continue
+		}
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
+
+// releaseFromApplication synthesizes a Release from an Argo CD
+// Application that deploys a Helm chart (spec.source.chart set). Git-
+// sourced manifests and Kustomize/plain-YAML applications are skipped
+// since they aren't Helm charts.
+func releaseFromApplication(app unstructured.Unstructured) (sources.Release, bool) {
+	chart, found, _ := unstructured.NestedString(app.Object, "spec", "source", "chart")
+	if !found || chart == "" {
+		return sources.Release{}, false
+	}
+
+	version, _, _ := unstructured.NestedString(app.Object, "spec", "source", "targetRevision")
+	namespace, _, _ := unstructured.NestedString(app.Object, "spec", "destination", "namespace")
+	if namespace == "" {
+		namespace = app.GetNamespace()
+	}
+
+	return sources.Release{
+		Chart:     chart,
+		Release:   app.GetName(),
+		Version:   version,
+		Namespace: namespace,
+		Updated:   app.GetCreationTimestamp().Time,
+	}, true
+}
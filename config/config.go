@@ -0,0 +1,45 @@
+// Package config loads the exporter's optional YAML config file, which
+// lets users configure the chart registries consulted for latest-version
+// lookups.
+package config
+
+import (
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/sstarcher/helm-exporter/registries"
+)
+
+// AppConfig holds the exporter's command-line configuration.
+type AppConfig struct {
+	ConfigFile string
+}
+
+// Config is the parsed contents of the (optional) config file.
+type Config struct {
+	HelmRegistries registries.HelmRegistries `yaml:"helmRegistries"`
+}
+
+// LoadConfiguration reads and parses the config file at path, returning
+// a zero-value Config if path is empty or the file can't be read or
+// parsed.
+func LoadConfiguration(path string) Config {
+	cfg := Config{}
+	if path == "" {
+		return cfg
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Warnf("failed to read config file %s with %v", path, err)
+		return cfg
+	}
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		log.Warnf("failed to parse config file %s with %v", path, err)
+		return Config{}
+	}
+	return cfg
+}
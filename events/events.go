@@ -0,0 +1,293 @@
+// Package events watches Helm's release storage (Secrets or ConfigMaps,
+// depending on HELM_DRIVER) and turns the revisions it observes into
+// Prometheus counters for installs, upgrades, uninstalls, and failures.
+//
+// The exporter doesn't wrap Helm CLI actions directly, so there is no
+// single place that sees an "install" or "upgrade" happen. Instead we
+// diff the revisions we see in storage against a small in-memory ledger:
+// a release name we haven't seen before is an install, a higher revision
+// number for a release we have seen is an upgrade, and a release that
+// disappears from storage is an uninstall.
+package events
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	cmap "github.com/orcaman/concurrent-map"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	rspb "helm.sh/helm/v3/pkg/release"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	installTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helm_release_install_total",
+			Help: "Total number of Helm release installs observed",
+		},
+		[]string{"chart", "namespace", "status", "cluster"},
+	)
+	upgradeTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helm_release_upgrade_total",
+			Help: "Total number of Helm release upgrades observed",
+		},
+		[]string{"chart", "namespace", "status", "cluster"},
+	)
+	uninstallTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helm_release_uninstall_total",
+			Help: "Total number of Helm release uninstalls observed",
+		},
+		[]string{"chart", "namespace", "status", "cluster"},
+	)
+	failedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helm_release_failed_total",
+			Help: "Total number of Helm release revisions observed in a failed state",
+		},
+		[]string{"chart", "namespace", "status", "cluster"},
+	)
+
+	// ledger tracks the highest revision we've counted for each
+	// cluster/namespace/release triple so that informer resyncs don't
+	// double count, and so that two clusters sharing a namespace and
+	// release name don't collide.
+	ledger = cmap.New()
+
+	// failedSeen dedupes failedTotal increments so that repeated
+	// updates/resyncs of the same failed revision are only counted once.
+	failedSeen = cmap.New()
+)
+
+func init() {
+	prometheus.MustRegister(installTotal, upgradeTotal, uninstallTotal, failedTotal)
+}
+
+// release is the subset of a Helm storage object we care about. Helm's
+// storage backends (Secrets and ConfigMaps) label every release object
+// with "owner=helm", "name", "status", and "version"; the chart name
+// isn't a label, so it's decoded from the gzipped, base64-encoded
+// release payload in the object's data instead.
+type release struct {
+	cluster   string
+	namespace string
+	name      string
+	chart     string
+	status    string
+	revision  int
+}
+
+func ledgerKey(cluster, namespace, name string) string {
+	return cluster + "/" + namespace + "/" + name
+}
+
+func failedKey(cluster, namespace, name string, revision int) string {
+	return cluster + "/" + namespace + "/" + name + "/" + strconv.Itoa(revision)
+}
+
+// Start begins watching the given Helm storage driver ("secret" or
+// "configmap", matching HELM_DRIVER) in namespace and updates the
+// install/upgrade/uninstall/failed counters as revisions come and go.
+// An empty namespace watches all namespaces. cluster labels the counters
+// so that releases with the same namespace and name in different
+// clusters are tracked independently.
+func Start(clientset kubernetes.Interface, cluster string, namespace string, driver string) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+
+	// synced is flipped to 1 once the informer's initial LIST has been
+	// processed, so that pre-existing releases seed the ledger instead
+	// of being counted as fresh installs on every exporter restart.
+	var synced int32
+
+	var informer cache.SharedIndexInformer
+	var handlerReg cache.ResourceEventHandlerRegistration
+	var err error
+	switch strings.ToLower(driver) {
+	case "configmap", "configmaps":
+		informer = factory.Core().V1().ConfigMaps().Informer()
+		handlerReg, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { observe(releaseFromConfigMap(cluster, obj.(*corev1.ConfigMap)), &synced) },
+			UpdateFunc: func(_, obj interface{}) { observe(releaseFromConfigMap(cluster, obj.(*corev1.ConfigMap)), &synced) },
+			DeleteFunc: func(obj interface{}) {
+				cm, ok := deletedObj(obj).(*corev1.ConfigMap)
+				if !ok {
+					log.Warnf("unexpected object type %T in configmap delete event", obj)
+					return
+				}
+				forget(releaseFromConfigMap(cluster, cm))
+			},
+		})
+	default:
+		informer = factory.Core().V1().Secrets().Informer()
+		handlerReg, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { observe(releaseFromSecret(cluster, obj.(*corev1.Secret)), &synced) },
+			UpdateFunc: func(_, obj interface{}) { observe(releaseFromSecret(cluster, obj.(*corev1.Secret)), &synced) },
+			DeleteFunc: func(obj interface{}) {
+				secret, ok := deletedObj(obj).(*corev1.Secret)
+				if !ok {
+					log.Warnf("unexpected object type %T in secret delete event", obj)
+					return
+				}
+				forget(releaseFromSecret(cluster, secret))
+			},
+		})
+	}
+	if err != nil {
+		log.Warnf("failed to register event handler for cluster %q namespace %s with %v", cluster, namespace, err)
+		return
+	}
+
+	stopper := make(chan struct{})
+	factory.Start(stopper)
+	// Wait on the handler registration's own HasSynced rather than the
+	// informer's: the informer reports synced as soon as the initial
+	// LIST lands in the store, but this handler's AddFunc callbacks run
+	// asynchronously off its own notification queue and may still be
+	// draining it, which would let pre-existing releases slip past the
+	// synced gate and get miscounted as fresh installs.
+	cache.WaitForCacheSync(stopper, handlerReg.HasSynced)
+	atomic.StoreInt32(&synced, 1)
+}
+
+// deletedObj unwraps the tombstone client-go delivers to a DeleteFunc
+// when a delete is observed via relist instead of a watch event, so
+// callers can type-assert the real object either way.
+func deletedObj(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+func releaseFromSecret(cluster string, secret *corev1.Secret) (release, bool) {
+	return releaseFrom(cluster, secret.Namespace, secret.Labels, secret.Data["release"])
+}
+
+func releaseFromConfigMap(cluster string, cm *corev1.ConfigMap) (release, bool) {
+	return releaseFrom(cluster, cm.Namespace, cm.Labels, []byte(cm.Data["release"]))
+}
+
+func releaseFrom(cluster, namespace string, labels map[string]string, payload []byte) (release, bool) {
+	if labels["owner"] != "helm" {
+		return release{}, false
+	}
+	revision, err := strconv.Atoi(labels["version"])
+	if err != nil {
+		return release{}, false
+	}
+	return release{
+		cluster:   cluster,
+		namespace: namespace,
+		name:      labels["name"],
+		chart:     chartNameFromPayload(payload),
+		status:    labels["status"],
+		revision:  revision,
+	}, true
+}
+
+// chartNameFromPayload decodes Helm's release storage payload (base64
+// then gzip then JSON) far enough to pull out the chart name, returning
+// an empty string if the payload can't be decoded.
+func chartNameFromPayload(payload []byte) string {
+	decoded, err := decodeReleasePayload(payload)
+	if err != nil {
+		return ""
+	}
+
+	var rel rspb.Release
+	if err := json.Unmarshal(decoded, &rel); err != nil {
+		return ""
+	}
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return ""
+	}
+	return rel.Chart.Metadata.Name
+}
+
+func decodeReleasePayload(payload []byte) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return ioutil.ReadAll(gz)
+}
+
+// observe records a release revision, bumping the install or upgrade
+// counter the first time we see it after the informer's initial sync,
+// and the failed counter the first time a given revision is seen in a
+// failed state.
+func observe(r release, ok bool, synced *int32) {
+	if !ok {
+		return
+	}
+
+	key := ledgerKey(r.cluster, r.namespace, r.name)
+	labels := prometheus.Labels{"chart": r.chart, "namespace": r.namespace, "status": r.status, "cluster": r.cluster}
+
+	previous, seen := ledger.Get(key)
+	if !seen || r.revision > previous.(int) {
+		ledger.Set(key, r.revision)
+	}
+
+	if atomic.LoadInt32(synced) == 1 {
+		if !seen {
+			installTotal.With(labels).Inc()
+		} else if r.revision > previous.(int) {
+			upgradeTotal.With(labels).Inc()
+		}
+	}
+
+	if r.status == "failed" {
+		fKey := failedKey(r.cluster, r.namespace, r.name, r.revision)
+		if _, alreadyCounted := failedSeen.Get(fKey); !alreadyCounted {
+			failedSeen.Set(fKey, true)
+			// Like the install/upgrade counters above, only count once
+			// the initial sync has completed, so pre-existing failed
+			// revisions seed failedSeen instead of being counted as
+			// fresh failures on every exporter restart.
+			if atomic.LoadInt32(synced) == 1 {
+				failedTotal.With(labels).Inc()
+			}
+		}
+	}
+}
+
+// forget removes a release revision from the ledger and, if it was the
+// highest revision we'd seen for that release, counts an uninstall.
+func forget(r release, ok bool) {
+	if !ok {
+		return
+	}
+
+	key := ledgerKey(r.cluster, r.namespace, r.name)
+	previous, seen := ledger.Get(key)
+	if !seen || previous.(int) != r.revision {
+		return
+	}
+
+	ledger.Remove(key)
+	log.Infof("release %s/%s removed from storage in cluster %s, counting as uninstall", r.namespace, r.name, r.cluster)
+	uninstallTotal.With(prometheus.Labels{"chart": r.chart, "namespace": r.namespace, "status": r.status, "cluster": r.cluster}).Inc()
+}
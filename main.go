@@ -1,13 +1,20 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/sstarcher/helm-exporter/argocd"
 	"github.com/sstarcher/helm-exporter/config"
+	"github.com/sstarcher/helm-exporter/events"
 	"github.com/sstarcher/helm-exporter/registries"
+	"github.com/sstarcher/helm-exporter/sources"
 
 	cmap "github.com/orcaman/concurrent-map"
 
@@ -21,6 +28,7 @@ import (
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/cli"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
 
@@ -36,14 +44,33 @@ var (
 	settings = cli.New()
 	clients  = cmap.New()
 
-	namespaces = flag.String("namespaces", "", "namespaces to monitor.  Defaults to all")
-	configFile = flag.String("config", "", "Configfile to load for helm overwrite registries.  Default is empty")
+	namespaces   = flag.String("namespaces", "", "namespaces to monitor.  Defaults to all")
+	kubeContexts = flag.String("kube-contexts", "", "comma separated list of kubeconfig contexts to watch across, fanning out a single exporter over multiple clusters. Defaults to the current context only")
+	configFile   = flag.String("config", "", "Configfile to load for helm overwrite registries.  Default is empty")
 
 	infoMetric             = flag.Bool("info-metric", true, "Generate info metric.  Defaults to true")
 	timestampMetric        = flag.Bool("timestamp-metric", true, "Generate timestamps metric.  Defaults to true")
 	countAllReleasesMetric = flag.Bool("count-all-releases-metric", true, "Generate count metric for all Helm releases. Defaults to true")
 
-	fetchLatest = flag.Bool("latest-chart-version", true, "Attempt to fetch the latest chart version from registries. Defaults to true")
+	fetchLatest     = flag.Bool("latest-chart-version", true, "Attempt to fetch the latest chart version from registries. Defaults to true")
+	refreshInterval = flag.Duration("latest-chart-refresh-interval", 15*time.Minute, "How often to refresh the latest-chart-version cache in the background. Defaults to 15m")
+	cacheFile       = flag.String("latest-chart-cache-file", "", "Optional path to persist the latest-chart-version cache across restarts. Defaults to in-memory only")
+
+	latestChartCache *registries.Cache
+
+	eventsMetric = flag.Bool("events-metric", true, "Generate install/upgrade/uninstall/failed counters by watching Helm's release storage. Defaults to true")
+
+	argocdSource    = flag.Bool("argocd", false, "Discover additional releases by listing Argo CD Applications. Defaults to false")
+	argocdNamespace = flag.String("argocd-namespace", "", "Namespace Argo CD Application resources live in, e.g. the Argo CD control-plane namespace. Defaults to all namespaces, since Applications don't necessarily live in --namespaces")
+
+	valuesDriftMetric = flag.Bool("values-drift-metric", true, "Generate a values-hash and drift gauge per release. Defaults to true")
+
+	releaseSources []namedReleaseSource
+
+	// valuesLedger remembers the last values hash observed for each
+	// release (keyed by "cluster/namespace/release") so Collect can tell
+	// a values change apart from a chart version bump.
+	valuesLedger = cmap.New()
 
 	statusCodeMap = map[string]float64{
 		"unknown":          0,
@@ -58,12 +85,59 @@ var (
 	}
 )
 
+// clusterConfig is a single kubeconfig context the exporter watches.
+// Watching more than one turns a single exporter deployment into a
+// fleet-wide Helm inventory endpoint.
+type clusterConfig struct {
+	name     string
+	settings *cli.EnvSettings
+}
+
+// watchedClient is what the clients cmap stores: an action.Configuration
+// tagged with the cluster it belongs to, so Collect can label metrics
+// with their source cluster.
+type watchedClient struct {
+	cluster string
+	config  *action.Configuration
+}
+
+// namedReleaseSource is what releaseSources stores: a sources.ReleaseSource
+// tagged with the cluster it was registered against.
+type namedReleaseSource struct {
+	cluster string
+	source  sources.ReleaseSource
+}
+
+// clusterConfigs returns the clusters the exporter should watch, one per
+// kubeconfig context in --kube-contexts, or a single unnamed cluster
+// using the default kubeconfig context if the flag is unset.
+func clusterConfigs() []clusterConfig {
+	if *kubeContexts == "" {
+		return []clusterConfig{{name: "", settings: settings}}
+	}
+
+	var configs []clusterConfig
+	for _, context := range strings.Split(*kubeContexts, ",") {
+		contextSettings := cli.New()
+		contextSettings.KubeContext = context
+		configs = append(configs, clusterConfig{name: context, settings: contextSettings})
+	}
+	return configs
+}
+
+// clientKey is the clients cmap key for a cluster/namespace pair.
+func clientKey(cluster, namespace string) string {
+	return cluster + "/" + namespace
+}
+
 // helmCollector is the struct for the Helm collector that contains
 // pointers to prometheus descriptors for each metric.
 type helmCollector struct {
 	revisionsCounterDesc    *prometheus.Desc
 	statsInfoGaugeDesc      *prometheus.Desc
 	statsTimestampGaugeDesc *prometheus.Desc
+	valuesDriftGaugeDesc    *prometheus.Desc
+	valuesInfoGaugeDesc     *prometheus.Desc
 }
 
 func initFlags() config.AppConfig {
@@ -79,26 +153,81 @@ func newHelmCollector() *helmCollector {
 		revisionsCounterDesc: prometheus.NewDesc(
 			"helm_chart_all_releases_total",
 			"Total Number of all Helm releases",
-			[]string{"chart", "release", "namespace"},
+			[]string{"chart", "release", "namespace", "cluster"},
 			nil,
 		),
 		statsInfoGaugeDesc: prometheus.NewDesc(
 			"helm_chart_info",
 			"Information on helm releases",
-			[]string{"chart", "release", "version", "appVersion", "updated", "namespace", "latestVersion"},
+			[]string{"chart", "release", "version", "appVersion", "updated", "namespace", "latestVersion", "source", "values_sha", "cluster"},
 			nil,
 		),
 		statsTimestampGaugeDesc: prometheus.NewDesc(
 			"helm_chart_timestamp",
 			"Timestamps of helm releases",
-			[]string{"chart", "release", "version", "appVersion", "updated", "namespace", "latestVersion"},
+			[]string{"chart", "release", "version", "appVersion", "updated", "namespace", "latestVersion", "source", "cluster"},
+			nil,
+		),
+		valuesDriftGaugeDesc: prometheus.NewDesc(
+			"helm_chart_values_drift",
+			"Whether a release's values have changed since the last scrape of the same chart version",
+			[]string{"chart", "release", "namespace", "cluster"},
 			nil,
 		),
+		valuesInfoGaugeDesc: prometheus.NewDesc(
+			"helm_chart_values_info",
+			"The hash of a release's merged values as of the last scrape",
+			[]string{"chart", "release", "namespace", "sha", "cluster"},
+			nil,
+		),
+	}
+}
+
+// valuesRecord is what valuesLedger stores per release: the chart
+// version and values hash observed on the previous scrape, so Collect
+// can tell a values change apart from a chart version bump.
+type valuesRecord struct {
+	version string
+	sha     string
+}
+
+// mergeValues deep-merges override (a release's user-supplied values)
+// over base (the chart's default values), the same precedence Helm
+// itself applies when rendering a release.
+func mergeValues(base, override map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		if baseMap, ok := out[k].(map[string]interface{}); ok {
+			if overrideMap, ok := v.(map[string]interface{}); ok {
+				out[k] = mergeValues(baseMap, overrideMap)
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// valuesHash returns a stable hash of a release's effective values
+// (chart defaults merged with user-supplied overrides). encoding/json
+// sorts map keys alphabetically, which is what makes this stable across
+// scrapes of the same values.
+func valuesHash(chartValues, releaseConfig map[string]interface{}) string {
+	merged := mergeValues(chartValues, releaseConfig)
+	data, err := json.Marshal(merged)
+	if err != nil {
+		log.Warnf("failed to hash release values with %v", err)
+		return ""
 	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
-func getLatestChartVersionFromHelm(name string, helmRegistries registries.HelmRegistries) (version string) {
-	version = helmRegistries.GetLatestVersionFromHelm(name)
+func getLatestChartVersionFromHelm(name string) (version string) {
+	version = latestChartCache.Get(name)
 	log.WithField("chart", name).Debugf("last chart repo version is  %v", version)
 	return
 }
@@ -107,20 +236,58 @@ func healthz(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func connect(namespace string) {
+func connect(cc clusterConfig, namespace string) {
 	actionConfig := new(action.Configuration)
-	err := actionConfig.Init(settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), log.Infof)
+	err := actionConfig.Init(cc.settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), log.Infof)
 	if err != nil {
-		log.Warnf("failed to connect to %s with %v", namespace, err)
+		log.Warnf("failed to connect to cluster %q namespace %s with %v", cc.name, namespace, err)
 	} else {
-		log.Infof("Watching namespace %s", namespace)
-		clients.Set(namespace, actionConfig)
+		log.Infof("Watching cluster %q namespace %s", cc.name, namespace)
+		clients.Set(clientKey(cc.name, namespace), watchedClient{cluster: cc.name, config: actionConfig})
+		if *eventsMetric {
+			watchEvents(cc, actionConfig, namespace)
+		}
+	}
+}
+
+// watchEvents starts the install/upgrade/uninstall/failed counters for
+// namespace in the background, using the same Helm storage driver the
+// rest of the exporter uses. Counters are labeled with cc.name so that
+// the same namespace and release name in different clusters don't
+// collide.
+func watchEvents(cc clusterConfig, actionConfig *action.Configuration, namespace string) {
+	clientset, err := actionConfig.KubernetesClientSet()
+	if err != nil {
+		log.Warnf("failed to start events watch for %s with %v", namespace, err)
+		return
+	}
+	go events.Start(clientset, cc.name, namespace, os.Getenv("HELM_DRIVER"))
+}
+
+// registerArgoCDSource adds an Argo CD ReleaseSource that discovers
+// charts deployed through Argo CD Applications in cc's cluster. Argo CD
+// Applications live in the Argo CD control-plane namespace, not the
+// namespace(s) the Helm discovery above watches, so this is controlled
+// separately by --argocd-namespace.
+func registerArgoCDSource(cc clusterConfig) {
+	restConfig, err := cc.settings.RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		log.Warnf("failed to build REST config for argocd discovery on cluster %q with %v", cc.name, err)
+		return
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		log.Warnf("failed to build dynamic client for argocd discovery on cluster %q with %v", cc.name, err)
+		return
 	}
+
+	releaseSources = append(releaseSources, namedReleaseSource{cluster: cc.name, source: argocd.New(dynamicClient, *argocdNamespace)})
 }
 
-func informer() {
+func informer(cc clusterConfig) {
 	actionConfig := new(action.Configuration)
-	err := actionConfig.Init(settings.RESTClientGetter(), settings.Namespace(), os.Getenv("HELM_DRIVER"), log.Infof)
+	err := actionConfig.Init(cc.settings.RESTClientGetter(), cc.settings.Namespace(), os.Getenv("HELM_DRIVER"), log.Infof)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -140,12 +307,12 @@ func informer() {
 			// "k8s.io/apimachinery/pkg/apis/meta/v1" provides an Object
 			// interface that allows us to get metadata easily
 			mObj := obj.(v1.Object)
-			connect(mObj.GetName())
+			connect(cc, mObj.GetName())
 		},
 		DeleteFunc: func(obj interface{}) {
 			mObj := obj.(v1.Object)
-			log.Infof("Removing namespace %s", mObj.GetName())
-			clients.Remove(mObj.GetName())
+			log.Infof("Removing cluster %q namespace %s", cc.name, mObj.GetName())
+			clients.Remove(clientKey(cc.name, mObj.GetName()))
 		},
 	})
 
@@ -163,13 +330,18 @@ func (c *helmCollector) Describe(ch chan<- *prometheus.Desc) {
 	if *timestampMetric == true {
 		ch <- c.statsTimestampGaugeDesc
 	}
+	if *valuesDriftMetric == true {
+		ch <- c.valuesDriftGaugeDesc
+		ch <- c.valuesInfoGaugeDesc
+	}
 }
 
 // Collect implements prometheus.Collector.
 // Collect implements required collect function for all promehteus collectors
 func (c *helmCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, client := range clients.Items() {
-		list := action.NewList(client.(*action.Configuration))
+	for _, raw := range clients.Items() {
+		wc := raw.(watchedClient)
+		list := action.NewList(wc.config)
 		items, err := list.Run()
 		if err != nil {
 			log.Warnf("got error while listing %v", err)
@@ -187,12 +359,14 @@ func (c *helmCollector) Collect(ch chan<- prometheus.Metric) {
 			latestVersion := ""
 
 			if *fetchLatest {
-				latestVersion = getLatestChartVersionFromHelm(item.Chart.Name(), cfg.HelmRegistries)
+				latestVersion = getLatestChartVersionFromHelm(item.Chart.Name())
 			}
 
-			helmRevisionsLabelValues := []string{chart, releaseName, namespace}
-			helmStatsInfoLabelValues := []string{chart, releaseName, version, appVersion, strconv.FormatInt(updated, 10), namespace, latestVersion}
-			helmStatsTimestampLabelValues := []string{chart, releaseName, version, appVersion, strconv.FormatInt(updated, 10), namespace, latestVersion}
+			sha := valuesHash(item.Chart.Values, item.Config)
+
+			helmRevisionsLabelValues := []string{chart, releaseName, namespace, wc.cluster}
+			helmStatsInfoLabelValues := []string{chart, releaseName, version, appVersion, strconv.FormatInt(updated, 10), namespace, latestVersion, "helm", sha, wc.cluster}
+			helmStatsTimestampLabelValues := []string{chart, releaseName, version, appVersion, strconv.FormatInt(updated, 10), namespace, latestVersion, "helm", wc.cluster}
 			ch <- prometheus.MustNewConstMetric(
 				c.revisionsCounterDesc,
 				prometheus.CounterValue,
@@ -216,7 +390,86 @@ func (c *helmCollector) Collect(ch chan<- prometheus.Metric) {
 					helmStatsTimestampLabelValues...,
 				)
 			}
+			if *valuesDriftMetric == true {
+				ch <- prometheus.MustNewConstMetric(
+					c.valuesInfoGaugeDesc,
+					prometheus.GaugeValue,
+					1,
+					chart, releaseName, namespace, sha, wc.cluster,
+				)
+				ch <- prometheus.MustNewConstMetric(
+					c.valuesDriftGaugeDesc,
+					prometheus.GaugeValue,
+					valuesDrift(wc.cluster, namespace, releaseName, version, sha),
+					chart, releaseName, namespace, wc.cluster,
+				)
+			}
+
+		}
+	}
+
+	c.collectReleaseSources(ch)
+}
+
+// valuesDrift returns 1 if release's values hash changed since the last
+// scrape of the same chart version, and 0 otherwise (including the
+// first scrape of a release, or any scrape where the chart version
+// itself changed, since that's an upgrade rather than drift).
+func valuesDrift(cluster, namespace, release, version, sha string) float64 {
+	key := cluster + "/" + namespace + "/" + release
+	drift := 0.0
+
+	if previous, ok := valuesLedger.Get(key); ok {
+		record := previous.(valuesRecord)
+		if record.version == version && record.sha != sha {
+			drift = 1
+		}
+	}
+
+	valuesLedger.Set(key, valuesRecord{version: version, sha: sha})
+	return drift
+}
+
+// collectReleaseSources emits helm_chart_info/helm_chart_timestamp for
+// every registered sources.ReleaseSource (e.g. Argo CD), tagged with its
+// name as the "source" label so they can be told apart from releases
+// discovered via Helm's own storage driver.
+func (c *helmCollector) collectReleaseSources(ch chan<- prometheus.Metric) {
+	for _, named := range releaseSources {
+		items, err := named.source.List()
+		if err != nil {
+			log.Warnf("got error while listing %s releases %v", named.source.Name(), err)
+			continue
+		}
+
+		for _, item := range items {
+			updated := item.Updated.Unix() * 1000
+			latestVersion := ""
+			if *fetchLatest {
+				latestVersion = getLatestChartVersionFromHelm(item.Chart)
+			}
+
+			timestampLabelValues := []string{item.Chart, item.Release, item.Version, item.AppVersion, strconv.FormatInt(updated, 10), item.Namespace, latestVersion, named.source.Name(), named.cluster}
+			// Release sources don't expose values, so values_sha is
+			// always empty for them.
+			infoLabelValues := []string{item.Chart, item.Release, item.Version, item.AppVersion, strconv.FormatInt(updated, 10), item.Namespace, latestVersion, named.source.Name(), "", named.cluster}
 
+			if *infoMetric == true {
+				ch <- prometheus.MustNewConstMetric(
+					c.statsInfoGaugeDesc,
+					prometheus.GaugeValue,
+					statusCodeMap["deployed"],
+					infoLabelValues...,
+				)
+			}
+			if *timestampMetric == true {
+				ch <- prometheus.MustNewConstMetric(
+					c.statsTimestampGaugeDesc,
+					prometheus.GaugeValue,
+					float64(updated),
+					timestampLabelValues...,
+				)
+			}
 		}
 	}
 }
@@ -232,11 +485,21 @@ func main() {
 	cliFlags := initFlags()
 	cfg = config.LoadConfiguration(cliFlags.ConfigFile)
 
-	if namespaces == nil || *namespaces == "" {
-		go informer()
-	} else {
-		for _, namespace := range strings.Split(*namespaces, ",") {
-			connect(namespace)
+	latestChartCache = registries.NewCache(cfg.HelmRegistries, *cacheFile)
+	latestChartCache.Load()
+	go latestChartCache.Start(*refreshInterval, make(chan struct{}))
+
+	for _, cc := range clusterConfigs() {
+		if namespaces == nil || *namespaces == "" {
+			go informer(cc)
+		} else {
+			for _, namespace := range strings.Split(*namespaces, ",") {
+				connect(cc, namespace)
+			}
+		}
+
+		if *argocdSource {
+			registerArgoCDSource(cc)
 		}
 	}
 
@@ -0,0 +1,173 @@
+package registries
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "helm_chart_latest_version_cache_hits_total",
+		Help: "Total number of latest-chart-version lookups served from cache",
+	})
+	cacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "helm_chart_latest_version_cache_misses_total",
+		Help: "Total number of latest-chart-version lookups not yet in cache",
+	})
+	cacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_chart_latest_version_cache_size",
+		Help: "Current number of charts tracked in the latest-chart-version cache",
+	})
+	lastRefreshTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "helm_chart_latest_version_last_refresh_timestamp",
+		Help: "Unix timestamp of the last completed latest-chart-version cache refresh, per registry. It is set for every configured registry whether or not its lookups succeeded",
+	}, []string{"registry"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheHitsTotal, cacheMissesTotal, cacheSize, lastRefreshTimestamp)
+}
+
+// Cache serves cached latest-chart-version lookups so that a Prometheus
+// scrape never blocks on an upstream chart repository. A background
+// goroutine (see Start) periodically re-resolves every chart the cache
+// has been asked about; Get only ever reads the in-memory map.
+type Cache struct {
+	registries HelmRegistries
+	path       string
+
+	mu       sync.RWMutex
+	versions map[string]string
+
+	group singleflight.Group
+}
+
+// NewCache returns a Cache that resolves latest versions using
+// registries. If path is non-empty, the cache is persisted to and
+// loaded from that file as JSON so it survives restarts.
+func NewCache(registries HelmRegistries, path string) *Cache {
+	return &Cache{
+		registries: registries,
+		path:       path,
+		versions:   map[string]string{},
+	}
+}
+
+// Get returns the cached latest version for chart. If chart hasn't been
+// looked up before, Get returns an empty string and schedules an
+// asynchronous resolution so a later scrape will see it.
+func (c *Cache) Get(chart string) string {
+	c.mu.RLock()
+	version, ok := c.versions[chart]
+	c.mu.RUnlock()
+
+	if ok {
+		cacheHitsTotal.Inc()
+		return version
+	}
+
+	cacheMissesTotal.Inc()
+	go c.resolve(chart)
+	return ""
+}
+
+// resolve looks up chart's latest version and stores it, coalescing
+// concurrent resolutions of the same chart across namespaces.
+func (c *Cache) resolve(chart string) {
+	v, _, _ := c.group.Do(chart, func() (interface{}, error) {
+		return c.registries.GetLatestVersionFromHelm(chart), nil
+	})
+	version, _ := v.(string)
+
+	c.mu.Lock()
+	c.versions[chart] = version
+	c.mu.Unlock()
+}
+
+// Refresh re-resolves every chart currently tracked by the cache. It's
+// meant to be called periodically by Start.
+func (c *Cache) Refresh() {
+	c.mu.RLock()
+	charts := make([]string, 0, len(c.versions))
+	for chart := range c.versions {
+		charts = append(charts, chart)
+	}
+	c.mu.RUnlock()
+
+	for _, chart := range charts {
+		c.resolve(chart)
+	}
+
+	now := float64(time.Now().Unix())
+	for _, reg := range c.registries.Registries {
+		lastRefreshTimestamp.WithLabelValues(reg.Name).Set(now)
+	}
+	cacheSize.Set(float64(len(charts)))
+
+	c.save()
+}
+
+// Start runs Refresh every interval until stop is closed.
+func (c *Cache) Start(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Refresh()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Load populates the cache from the on-disk cache file, if one was
+// configured and exists. It's a no-op otherwise.
+func (c *Cache) Load() {
+	if c.path == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		log.Debugf("no latest-chart-version cache file to load at %s: %v", c.path, err)
+		return
+	}
+
+	versions := map[string]string{}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		log.Warnf("failed to parse latest-chart-version cache file %s with %v", c.path, err)
+		return
+	}
+
+	c.mu.Lock()
+	c.versions = versions
+	c.mu.Unlock()
+}
+
+// save persists the cache to disk, if a cache file was configured.
+func (c *Cache) save() {
+	if c.path == "" {
+		return
+	}
+
+	c.mu.RLock()
+	data, err := json.Marshal(c.versions)
+	c.mu.RUnlock()
+	if err != nil {
+		log.Warnf("failed to marshal latest-chart-version cache with %v", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(c.path, data, 0644); err != nil {
+		log.Warnf("failed to write latest-chart-version cache file %s with %v", c.path, err)
+	}
+}
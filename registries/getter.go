@@ -0,0 +1,196 @@
+package registries
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Getter knows how to find the latest published version of a chart on a
+// Registry. It mirrors Helm's own pkg/getter in spirit: a small
+// interface so new chart sources (classic HTTP repos, OCI registries,
+// and anything else users point the exporter at) plug in the same way.
+type Getter interface {
+	// LatestVersion returns the latest version of chart available on
+	// reg, or an empty string if the chart isn't found there.
+	LatestVersion(reg Registry, chart string) (string, error)
+}
+
+// httpGetter looks up the latest chart version from a classic HTTP(S)
+// chart repository's index.yaml, using Helm's own getter package so
+// basic-auth and TLS client certs configured per-registry work the same
+// way they do for `helm repo add`. Bearer tokens are sent with a plain
+// net/http request (see fetchIndex).
+type httpGetter struct{}
+
+func (httpGetter) LatestVersion(reg Registry, chart string) (string, error) {
+	indexURL := strings.TrimSuffix(reg.URL, "/") + "/index.yaml"
+
+	data, err := fetchIndex(reg, indexURL)
+	if err != nil {
+		return "", err
+	}
+
+	index, err := repo.LoadIndex(data)
+	if err != nil {
+		return "", err
+	}
+	index.SortEntries()
+
+	entries, ok := index.Entries[chart]
+	if !ok || len(entries) == 0 {
+		return "", nil
+	}
+	return entries[0].Version, nil
+}
+
+// fetchIndex fetches indexURL, authenticating the request the way reg is
+// configured. Bearer-token auth needs an Authorization header that
+// Helm's own getter package has no option for, so that case is handled
+// with a plain net/http request instead.
+func fetchIndex(reg Registry, indexURL string) ([]byte, error) {
+	if reg.Auth.BearerToken != "" {
+		return fetchIndexWithBearerToken(reg, indexURL)
+	}
+
+	g, err := getter.NewHTTPGetter(httpGetterOptions(reg)...)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := g.Get(indexURL)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func fetchIndexWithBearerToken(reg Registry, indexURL string) ([]byte, error) {
+	tlsConfig, err := tlsClientConfig(reg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	req, err := http.NewRequest(http.MethodGet, indexURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+reg.Auth.BearerToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s fetching %s", resp.Status, indexURL)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// tlsClientConfig builds the TLS config for reg's optional client cert
+// and CA, honoring Insecure the same way as the non-bearer-token path.
+func tlsClientConfig(reg Registry) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: reg.Auth.Insecure} // nolint:gosec
+
+	if reg.Auth.CertFile != "" && reg.Auth.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(reg.Auth.CertFile, reg.Auth.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if reg.Auth.CAFile != "" {
+		ca, err := ioutil.ReadFile(reg.Auth.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func httpGetterOptions(reg Registry) []getter.Option {
+	var opts []getter.Option
+	if reg.Auth.Username != "" || reg.Auth.Password != "" {
+		opts = append(opts, getter.WithBasicAuth(reg.Auth.Username, reg.Auth.Password))
+	}
+	if reg.Auth.CertFile != "" || reg.Auth.KeyFile != "" || reg.Auth.CAFile != "" {
+		opts = append(opts, getter.WithTLSClientConfig(reg.Auth.CertFile, reg.Auth.KeyFile, reg.Auth.CAFile))
+	}
+	if reg.Auth.Insecure {
+		opts = append(opts, getter.WithInsecureSkipVerifyTLS(true))
+	}
+	return opts
+}
+
+// ociGetter looks up the latest chart version by listing tags on an OCI
+// registry (url of the form "oci://host/path", with the chart name
+// appended to form the repository reference), since OCI-based
+// charts have no index.yaml to fetch.
+type ociGetter struct{}
+
+func (ociGetter) LatestVersion(reg Registry, chart string) (string, error) {
+	var opts []registry.ClientOption
+	if reg.Auth.Insecure {
+		opts = append(opts, registry.ClientOptInsecure(true))
+	}
+
+	client, err := registry.NewClient(opts...)
+	if err != nil {
+		return "", err
+	}
+
+	if reg.Auth.Username != "" || reg.Auth.Password != "" {
+		if err := client.Login(ociHost(reg.URL),
+			registry.LoginOptBasicAuth(reg.Auth.Username, reg.Auth.Password),
+			registry.LoginOptInsecure(reg.Auth.Insecure),
+		); err != nil {
+			return "", err
+		}
+	}
+
+	ref := strings.TrimSuffix(strings.TrimPrefix(reg.URL, "oci://"), "/") + "/" + chart
+	tags, err := client.Tags(ref)
+	if err != nil {
+		return "", err
+	}
+
+	var latest *semver.Version
+	for _, tag := range tags {
+		version, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if latest == nil || version.GreaterThan(latest) {
+			latest = version
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	return latest.Original(), nil
+}
+
+func ociHost(url string) string {
+	host := strings.TrimPrefix(url, "oci://")
+	if idx := strings.Index(host, "/"); idx != -1 {
+		host = host[:idx]
+	}
+	return host
+}
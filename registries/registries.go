@@ -0,0 +1,86 @@
+// Package registries looks up the latest available version of a Helm
+// chart from the chart repositories and OCI registries it's published
+// to.
+package registries
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fetchErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "helm_chart_latest_version_fetch_errors_total",
+		Help: "Total number of errors encountered looking up the latest chart version from a registry",
+	},
+	[]string{"registry"},
+)
+
+func init() {
+	prometheus.MustRegister(fetchErrorsTotal)
+}
+
+// Auth holds the optional per-registry credentials configured in the
+// exporter's config file. Only the fields relevant to a given registry
+// need to be set.
+type Auth struct {
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	BearerToken string `yaml:"bearerToken"`
+	CertFile    string `yaml:"certFile"`
+	KeyFile     string `yaml:"keyFile"`
+	CAFile      string `yaml:"caFile"`
+	Insecure    bool   `yaml:"insecure"`
+}
+
+// Registry is a single chart source the exporter knows how to look up
+// the latest version from: either a classic HTTP chart repository or an
+// OCI registry, distinguished by URL scheme (e.g. "oci://ghcr.io/acme").
+type Registry struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	Auth Auth   `yaml:"auth"`
+}
+
+// IsOCI reports whether r is an OCI registry rather than a classic HTTP
+// chart repository.
+func (r Registry) IsOCI() bool {
+	return strings.HasPrefix(r.URL, "oci://")
+}
+
+// HelmRegistries is the set of registries the exporter consults when
+// looking up a chart's latest published version.
+type HelmRegistries struct {
+	Registries []Registry `yaml:"registries"`
+}
+
+// getterFor returns the Getter capable of querying reg.
+func getterFor(reg Registry) Getter {
+	if reg.IsOCI() {
+		return ociGetter{}
+	}
+	return httpGetter{}
+}
+
+// GetLatestVersionFromHelm returns the latest version of chart from the
+// first configured registry, in order, that publishes it; versions in
+// later registries are not compared. It returns an empty string if it
+// couldn't be determined from any of them. Each registry that fails to answer bumps
+// helm_chart_latest_version_fetch_errors_total and is otherwise skipped.
+func (h HelmRegistries) GetLatestVersionFromHelm(chart string) string {
+	for _, reg := range h.Registries {
+		version, err := getterFor(reg).LatestVersion(reg, chart)
+		if err != nil {
+			log.WithField("chart", chart).Debugf("failed to fetch latest version from %s: %v", reg.Name, err)
+			fetchErrorsTotal.WithLabelValues(reg.Name).Inc()
+			continue
+		}
+		if version != "" {
+			return version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+// Package sources defines the pluggable interface the exporter uses to
+// discover Helm-chart-shaped releases beyond what Helm's own storage
+// driver knows about, e.g. charts deployed via a GitOps controller that
+// renders and applies them itself.
+package sources
+
+import "time"
+
+// Release is a synthesized, helm_chart_info-shaped view of something
+// that looks like a Helm release, regardless of how it was discovered.
+type Release struct {
+	Chart      string
+	Release    string
+	Version    string
+	AppVersion string
+	Namespace  string
+	Updated    time.Time
+}
+
+// ReleaseSource discovers releases beyond Helm's native storage driver.
+// The collector lists every registered ReleaseSource alongside the
+// native Helm release list produced via action.List, tagging the
+// metrics it produces with Name() as the "source" label.
+type ReleaseSource interface {
+	// Name identifies the source and is used as the "source" label on
+	// the metrics it produces, e.g. "argocd".
+	Name() string
+
+	// List returns the releases currently known to this source.
+	List() ([]Release, error)
+}